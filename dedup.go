@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+)
+
+// Dedup reads keys from in and returns a channel of only the first
+// observation of each key; duplicates, as reported by Observe, are dropped.
+// The returned channel is closed once in is closed and drained.
+func (i *InverseBloomFilter) Dedup(in <-chan []byte) <-chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		for key := range in {
+			if !i.Observe(key) {
+				out <- key
+			}
+		}
+	}()
+	return out
+}
+
+// dedupWriter is the io.Writer (and io.Closer) returned by NewDedupWriter.
+type dedupWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func (d *dedupWriter) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// Close signals that no more data will be written, then blocks until the
+// goroutine started by NewDedupWriter has drained the pipe and made its
+// final write to the downstream io.Writer, so that it is safe for the
+// caller to close or reuse that io.Writer once Close returns. It implements
+// io.Closer.
+func (d *dedupWriter) Close() error {
+	err := d.pw.Close()
+	<-d.done
+	return err
+}
+
+// NewDedupWriter returns an io.Writer that splits bytes written to it into
+// records using split, drops records that have already been observed by an
+// internal InverseBloomFilter of the given size, and writes the rest to w
+// followed by a newline. size is clamped to a valid InverseBloomFilter size
+// rather than returned as an error, to keep this constructor's signature a
+// plain io.Writer. The returned Writer also implements io.Closer; callers
+// must Close it when done writing, which blocks until the internal
+// goroutine has made its last write to w, before closing or reusing w
+// themselves.
+func NewDedupWriter(w io.Writer, size int, split bufio.SplitFunc) io.Writer {
+	if size <= 0 {
+		size = 1
+	}
+	if size > MaxSize {
+		size = MaxSize
+	}
+	filter, _ := NewInverseBloomFilter(size)
+
+	pr, pw := io.Pipe()
+	scanner := bufio.NewScanner(pr)
+	scanner.Split(split)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for scanner.Scan() {
+			record := append([]byte(nil), scanner.Bytes()...)
+			if filter.Observe(record) {
+				continue
+			}
+			if _, err := w.Write(record); err != nil {
+				pr.CloseWithError(err)
+				return
+			}
+			if _, err := w.Write([]byte("\n")); err != nil {
+				pr.CloseWithError(err)
+				return
+			}
+		}
+		pr.CloseWithError(scanner.Err())
+	}()
+
+	return &dedupWriter{pw: pw, done: done}
+}
+
+// DedupScanner wraps a bufio.Scanner, skipping tokens that have already been
+// observed by its InverseBloomFilter so Scan only stops on a first
+// observation or end of input.
+type DedupScanner struct {
+	*bufio.Scanner
+	filter *InverseBloomFilter
+}
+
+// NewDedupScanner returns a DedupScanner that reads from r and deduplicates
+// tokens against i.
+func (i *InverseBloomFilter) NewDedupScanner(r io.Reader) *DedupScanner {
+	return &DedupScanner{Scanner: bufio.NewScanner(r), filter: i}
+}
+
+// Scan advances past duplicate tokens, returning true once it lands on a
+// first observation, or false once the underlying Scanner is exhausted.
+func (d *DedupScanner) Scan() bool {
+	for d.Scanner.Scan() {
+		key := append([]byte(nil), d.Scanner.Bytes()...)
+		if !d.filter.Observe(key) {
+			return true
+		}
+	}
+	return false
+}
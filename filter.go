@@ -15,12 +15,13 @@ package filter
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
-	"hash"
+	"fmt"
 	"hash/fnv"
 	"math"
+	"sync"
 	"sync/atomic"
-	"unsafe"
 )
 
 var (
@@ -32,23 +33,174 @@ var (
 	// than or equal to zero.
 	ErrSizeTooSmall = errors.New("Cannot have a zero or negative size")
 
+	// ErrHasherIDRequired is returned by NewInverseBloomFilterWithHasher when
+	// id is empty. Every distinct Hasher needs its own id so that
+	// MarshalBinary/Merge can detect, rather than silently corrupt, data
+	// produced by a different hash function.
+	ErrHasherIDRequired = errors.New("Hasher id must be non-empty")
+
 	// MaxSize indicates the largest possible filter size.
 	MaxSize = 1 << 30
 )
 
+// hasherIDFNV64a prefixes the identifier of the default FNV-based Hasher;
+// the seed is appended so that filters seeded differently - and therefore
+// disagreeing on where every key hashes to - are never mistaken for one
+// another by MarshalBinary/Merge.
+const hasherIDFNV64a = "fnv64a"
+
+// slotsPerBlock is the number of slots grouped into one 64-byte block: each
+// slot's word is a uint64 (8 bytes) and its cached hash a uint32 (4 bytes),
+// and slotsPerBlock*(8+4) plus the padding below is exactly one cache line.
+const slotsPerBlock = 4
+
+// block is one 64-byte cache line of slotsPerBlock slots. A slot's state
+// lives in two places: words[n] is the authoritative, atomically-swapped
+// descriptor of the key stored in the arena (see packWord), and hashes[n] is
+// a best-effort cache of that key's hash, written just after words[n] so
+// that Check can reject a non-matching slot by comparing a uint32 before it
+// ever reads the key's bytes out of the arena. hashes[n] is allowed to lag
+// words[n] by the span of a single concurrent Observe; the worst case is an
+// extra byte comparison or an extra false negative, both already within this
+// filter's contract.
+type block struct {
+	words  [slotsPerBlock]uint64
+	hashes [slotsPerBlock]uint32
+	_      [16]byte // pad to 64 bytes
+}
+
+// occupiedBit marks a slot word as holding a key, distinguishing a
+// zero-length key from an empty slot.
+const occupiedBit = uint64(1) << 63
+
+// offsetShift is where the 32-bit arena offset starts within a slot word,
+// leaving the 31 bits below it for the key's length.
+const offsetShift = 31
+
+// lengthMask isolates the 31-bit length field of a slot word.
+const lengthMask = uint64(1)<<offsetShift - 1
+
+// packWord encodes a key's location in the arena - byte offset and length -
+// into the single uint64 that Observe swaps atomically to claim a slot.
+func packWord(offset, length uint32) uint64 {
+	return occupiedBit | uint64(offset)<<offsetShift | uint64(length)&lengthMask
+}
+
+// unpackWord is packWord's inverse.
+func unpackWord(word uint64) (occupied bool, offset, length uint32) {
+	occupied = word&occupiedBit != 0
+	offset = uint32(word >> offsetShift)
+	length = uint32(word & lengthMask)
+	return
+}
+
+// arena is an append-only byte buffer that backs every slot's key in an
+// InverseBloomFilter, so Observe stores a key by copying it into one shared
+// buffer instead of allocating a new []byte (and slice header) per call.
+// Appends are serialized by mu; reads take a copy so callers never hold a
+// reference into a buffer that a concurrent append may grow and replace.
+type arena struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// append copies key onto the end of the arena and returns where it landed.
+func (a *arena) append(key []byte) (offset, length uint32) {
+	a.mu.Lock()
+	offset = uint32(len(a.buf))
+	a.buf = append(a.buf, key...)
+	a.mu.Unlock()
+	return offset, uint32(len(key))
+}
+
+// read returns a copy of the length bytes of the arena starting at offset.
+func (a *arena) read(offset, length uint32) []byte {
+	a.mu.Lock()
+	key := append([]byte(nil), a.buf[offset:offset+length]...)
+	a.mu.Unlock()
+	return key
+}
+
+// reset discards the arena's contents, letting its backing buffer be
+// garbage collected once nothing still references it.
+func (a *arena) reset() {
+	a.mu.Lock()
+	a.buf = nil
+	a.mu.Unlock()
+}
+
+// Hasher is a function that hashes key to a 64-bit value. Implementations do
+// not need to be cryptographically secure, but should distribute keys
+// uniformly across the full 64-bit range. Callers may supply their own
+// Hasher (xxhash, wyhash, siphash, etc.) via NewInverseBloomFilterWithHasher
+// to trade off speed, distribution quality, or resistance to adversarial
+// collisions.
+type Hasher func(key []byte) uint64
+
 // InverseBloomFilter is a concurrent, probabilistic data structure which can
 // be thought of as the "opposite" of a Bloom filter. It may report a false
 // negative but can never report a false positive.
+//
+// Its backing array is a flat []block of 64-byte cache-line blocks (see
+// block) rather than a []*[]byte: each slot's key lives in a shared arena,
+// and the slot itself holds only a packed offset/length word. Because that
+// word is swapped, never compared-and-swapped, Observe never loops - a
+// single atomic.SwapUint64 both claims the slot and reports what was there
+// before - and it no longer allocates a slice header per call: it allocates
+// when the arena's buffer needs to grow, and when the slot was occupied, to
+// copy the old key out of the arena for comparison.
 type InverseBloomFilter struct {
-	array    []*[]byte
-	sizeMask uint32
-	hash     *uintHash
+	array    []block
+	sizeMask uint64
+	hash     Hasher
+	hasherID string
+	arena    *arena
 }
 
 // NewInverseBloomFilter creates and returns a new InverseBloomFilter with the
 // specified capacity. It returns an error if the size is not between 0 and
-// MaxSize.
+// MaxSize. It uses an FNV-based Hasher with no seed; use
+// NewInverseBloomFilterWithSeed or NewInverseBloomFilterWithHasher to
+// customize either of those.
 func NewInverseBloomFilter(size int) (*InverseBloomFilter, error) {
+	return NewInverseBloomFilterWithSeed(size, 0)
+}
+
+// NewInverseBloomFilterWithSeed creates and returns a new InverseBloomFilter
+// using the default FNV-based Hasher seeded with seed. Giving independently
+// constructed filters distinct seeds causes them to disagree on which keys
+// collide, which defeats an adversary crafting inputs to collide against a
+// known, unseeded hash. The filter's hasher identifier encodes seed, so
+// MarshalBinary/Merge reject data from a filter seeded differently instead
+// of silently merging it as if it hashed the same way.
+func NewInverseBloomFilterWithSeed(size int, seed uint64) (*InverseBloomFilter, error) {
+	id := fmt.Sprintf("%s:%x", hasherIDFNV64a, seed)
+	return newInverseBloomFilter(size, fnvHasher(seed), id)
+}
+
+// NewInverseBloomFilterWithHasher creates and returns a new
+// InverseBloomFilter with the specified capacity that hashes keys using h,
+// identified by id. It returns an error if the size is not between 0 and
+// MaxSize, or ErrHasherIDRequired if id is empty. This allows callers to
+// plug in a faster or better-distributed hash function, such as xxhash or
+// siphash, in place of the default FNV-based one.
+//
+// id is recorded by MarshalBinary/WriteTo and checked by
+// UnmarshalBinary/ReadFrom/Merge, which reject data produced by a filter
+// with a different id. Callers must give every distinct h (including the
+// same h seeded differently) its own id, or merging/restoring across
+// differently-hashing filters will silently place keys at slots the
+// receiver's own Hasher will never look up again.
+func NewInverseBloomFilterWithHasher(size int, id string, h Hasher) (*InverseBloomFilter, error) {
+	if id == "" {
+		return nil, ErrHasherIDRequired
+	}
+	return newInverseBloomFilter(size, h, id)
+}
+
+// newInverseBloomFilter is the shared implementation behind
+// NewInverseBloomFilterWithSeed and NewInverseBloomFilterWithHasher.
+func newInverseBloomFilter(size int, h Hasher, id string) (*InverseBloomFilter, error) {
 	if size > MaxSize {
 		return nil, ErrSizeTooLarge
 	}
@@ -56,11 +208,15 @@ func NewInverseBloomFilter(size int) (*InverseBloomFilter, error) {
 		return nil, ErrSizeTooSmall
 	}
 
-	// Round to the next largest power of two.
+	// Round to the next largest power of two, then up again to a whole
+	// number of blocks.
 	size = int(math.Pow(2, math.Ceil(math.Log2(float64(size)))))
-	slice := make([]*[]byte, size)
-	sizeMask := uint32(size - 1)
-	return &InverseBloomFilter{slice, sizeMask, &uintHash{fnv.New32a()}}, nil
+	if size < slotsPerBlock {
+		size = slotsPerBlock
+	}
+	blocks := make([]block, size/slotsPerBlock)
+	sizeMask := uint64(size - 1)
+	return &InverseBloomFilter{blocks, sizeMask, h, id, &arena{}}, nil
 }
 
 // Observe marks a key as observed. It returns true if the key has been
@@ -69,47 +225,91 @@ func NewInverseBloomFilter(size int) (*InverseBloomFilter, error) {
 // That is, it may return false even though the key was previously observed,
 // but it will never return true for a key that has never been observed.
 func (i *InverseBloomFilter) Observe(key []byte) bool {
-	i.hash.Write(key)
-	uindex := i.hash.Sum32() & i.sizeMask
-	i.hash.Reset()
-	oldId := getAndSet(i.array, int32(uindex), key)
-	return bytes.Equal(oldId, key)
+	hit, _ := i.observe(key)
+	return hit
+}
+
+// observe is Observe's implementation, additionally reporting whether the
+// slot the key landed in was previously unoccupied. ScalableInverseBloomFilter
+// uses firstInsert to estimate a layer's fill without a separate counting
+// pass over the array.
+func (i *InverseBloomFilter) observe(key []byte) (hit, firstInsert bool) {
+	h := i.hash(key)
+	index := h & i.sizeMask
+
+	offset, length := i.arena.append(key)
+	oldWord := atomic.SwapUint64(i.wordSlot(index), packWord(offset, length))
+	atomic.StoreUint32(i.hashSlot(index), uint32(h))
+
+	occupied, oldOffset, oldLength := unpackWord(oldWord)
+	if !occupied {
+		return false, true
+	}
+	return bytes.Equal(i.arena.read(oldOffset, oldLength), key), false
+}
+
+// Check reports whether key has possibly been observed, without recording an
+// observation of it. Like Observe, it may return false even though the key
+// was previously observed, but it will never return true for a key that has
+// never been observed.
+func (i *InverseBloomFilter) Check(key []byte) bool {
+	h := i.hash(key)
+	index := h & i.sizeMask
+
+	word := atomic.LoadUint64(i.wordSlot(index))
+	occupied, offset, length := unpackWord(word)
+	if !occupied {
+		return false
+	}
+	if atomic.LoadUint32(i.hashSlot(index)) != uint32(h) {
+		return false
+	}
+	return bytes.Equal(i.arena.read(offset, length), key)
 }
 
 // Size returns the filter length.
 func (i *InverseBloomFilter) Size() int {
-	return len(i.array)
+	return len(i.array) * slotsPerBlock
 }
 
-type uintHash struct {
-	hash.Hash
+// Reset clears every slot, forgetting all observations, and empties the
+// arena so its old backing buffer can be garbage collected rather than
+// leaking every key ever observed. TTLInverseBloomFilter uses this to zero
+// its oldest generation in place before recycling it as the newest one,
+// rather than allocating a replacement filter.
+func (i *InverseBloomFilter) Reset() {
+	for b := range i.array {
+		for s := range i.array[b].words {
+			atomic.StoreUint64(&i.array[b].words[s], 0)
+			atomic.StoreUint32(&i.array[b].hashes[s], 0)
+		}
+	}
+	i.arena.reset()
 }
 
-func (u uintHash) Sum32() uint32 {
-	sum := u.Sum(nil)
-	x := uint32(sum[0])
-	for _, val := range sum[1:3] {
-		x = x << 3
-		x += uint32(val)
-	}
-	return x
-}
-
-// getAndSet returns the key that was in the slice at the given index after
-// putting the new key in the slice at that index, atomically.
-func getAndSet(arr []*[]byte, index int32, key []byte) []byte {
-	indexPtr := (*unsafe.Pointer)(unsafe.Pointer(&arr[index]))
-	keyUnsafe := unsafe.Pointer(&key)
-	var oldKey []byte
-	for {
-		oldKeyUnsafe := atomic.LoadPointer(indexPtr)
-		if atomic.CompareAndSwapPointer(indexPtr, oldKeyUnsafe, keyUnsafe) {
-			oldKeyPtr := (*[]byte)(oldKeyUnsafe)
-			if oldKeyPtr != nil {
-				oldKey = *oldKeyPtr
-			}
-			break
-		}
+// wordSlot returns the address of the slot word that index maps to, for use
+// with the atomic package.
+func (i *InverseBloomFilter) wordSlot(index uint64) *uint64 {
+	return &i.array[index/slotsPerBlock].words[index%slotsPerBlock]
+}
+
+// hashSlot returns the address of the cached hash that index maps to, for
+// use with the atomic package.
+func (i *InverseBloomFilter) hashSlot(index uint64) *uint32 {
+	return &i.array[index/slotsPerBlock].hashes[index%slotsPerBlock]
+}
+
+// fnvHasher returns the default Hasher, a 64-bit FNV-1a hash salted with
+// seed. Unlike the previous Sum32-based hash, the full 64-bit digest is used,
+// which removes the truncation that limited filter size and caused avoidable
+// collisions.
+func fnvHasher(seed uint64) Hasher {
+	var seedBytes [8]byte
+	binary.LittleEndian.PutUint64(seedBytes[:], seed)
+	return func(key []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(seedBytes[:])
+		h.Write(key)
+		return h.Sum64()
 	}
-	return oldKey
 }
@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultLoadFactor is the fraction of a layer's capacity that, once filled
+// with first-time observations, triggers ScalableInverseBloomFilter to
+// allocate a new, larger layer.
+const defaultLoadFactor = 0.5
+
+// scalableLayer is one generation of a ScalableInverseBloomFilter: an
+// InverseBloomFilter plus a running count of the first-time inserts it has
+// recorded, used to estimate how full it is without scanning its array.
+type scalableLayer struct {
+	filter *InverseBloomFilter
+	fill   uint64
+}
+
+// ScalableInverseBloomFilter is a concurrent, probabilistic data structure
+// that behaves like InverseBloomFilter — it may report a false negative but
+// can never report a false positive — but grows automatically as it fills
+// up, rather than dropping old keys. It maintains a chain of
+// InverseBloomFilters of geometrically increasing size and checks all of
+// them on Observe, which bounds the false-negative rate even when duplicate
+// events are spaced far apart, a case the fixed-size InverseBloomFilter
+// handles poorly.
+type ScalableInverseBloomFilter struct {
+	mu         sync.RWMutex
+	layers     []*scalableLayer
+	size0      int
+	growth     float64
+	maxLayers  int
+	loadFactor float64
+}
+
+// NewScalableInverseBloomFilter creates and returns a new
+// ScalableInverseBloomFilter whose first layer has capacity initialSize.
+// Each subsequent layer's capacity is size0 * growth^n, up to maxLayers
+// layers; once maxLayers is reached, the newest layer is reused instead of
+// growing further. It returns an error if initialSize is not a valid
+// InverseBloomFilter size.
+func NewScalableInverseBloomFilter(initialSize int, growth float64, maxLayers int) (*ScalableInverseBloomFilter, error) {
+	first, err := NewInverseBloomFilter(initialSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ScalableInverseBloomFilter{
+		layers:     []*scalableLayer{{filter: first}},
+		size0:      first.Size(),
+		growth:     growth,
+		maxLayers:  maxLayers,
+		loadFactor: defaultLoadFactor,
+	}, nil
+}
+
+// SetLoadFactor overrides the fraction of a layer's capacity that, once
+// filled, triggers allocating a new layer. The default is 0.5.
+func (s *ScalableInverseBloomFilter) SetLoadFactor(loadFactor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadFactor = loadFactor
+}
+
+// Observe marks a key as observed. It returns true if the key has been
+// previously observed by any layer and false if it has possibly not been
+// observed yet. Like InverseBloomFilter.Observe, it may report a false
+// negative but will never report a false positive.
+func (s *ScalableInverseBloomFilter) Observe(key []byte) bool {
+	s.mu.RLock()
+	newest := s.layers[len(s.layers)-1]
+	hit, firstInsert := newest.filter.observe(key)
+	if firstInsert {
+		atomic.AddUint64(&newest.fill, 1)
+	}
+	if !hit {
+		for idx := len(s.layers) - 2; idx >= 0; idx-- {
+			if s.layers[idx].filter.Check(key) {
+				hit = true
+				break
+			}
+		}
+	}
+	full := float64(atomic.LoadUint64(&newest.fill))/float64(newest.filter.Size()) >= s.loadFactor
+	s.mu.RUnlock()
+
+	if full {
+		s.grow()
+	}
+
+	return hit
+}
+
+// grow appends a new, larger layer if the newest layer is still at or above
+// the load factor and maxLayers has not been reached. It re-checks the
+// condition under the write lock so concurrent callers don't each append a
+// layer for the same fill event.
+func (s *ScalableInverseBloomFilter) grow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newest := s.layers[len(s.layers)-1]
+	if float64(atomic.LoadUint64(&newest.fill))/float64(newest.filter.Size()) < s.loadFactor {
+		return
+	}
+	if s.maxLayers > 0 && len(s.layers) >= s.maxLayers {
+		return
+	}
+
+	n := len(s.layers)
+	size := int(float64(s.size0) * math.Pow(s.growth, float64(n)))
+	next, err := NewInverseBloomFilter(size)
+	if err != nil {
+		return
+	}
+	s.layers = append(s.layers, &scalableLayer{filter: next})
+}
+
+// Compact drops the oldest layer, trading an increased false-negative rate
+// for a bounded memory footprint. It is a no-op if only one layer remains.
+func (s *ScalableInverseBloomFilter) Compact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.layers) <= 1 {
+		return
+	}
+	s.layers = s.layers[1:]
+}
+
+// Layers returns the number of InverseBloomFilter layers currently
+// maintained.
+func (s *ScalableInverseBloomFilter) Layers() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.layers)
+}
@@ -0,0 +1,62 @@
+package filter
+
+import "testing"
+
+func TestScalableInverseBloomFilterGrowsAndDetectsHits(t *testing.T) {
+	s, err := NewScalableInverseBloomFilter(8, 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLoadFactor(0.5)
+
+	for i := 0; i < 200; i++ {
+		s.Observe([]byte{byte(i), byte(i >> 8)})
+	}
+	if got := s.Layers(); got <= 1 {
+		t.Fatalf("Layers() = %d after many distinct inserts, want > 1", got)
+	}
+
+	key := []byte{1, 0}
+	if !s.Observe(key) {
+		t.Error("Observe on a previously observed key = false, want true")
+	}
+}
+
+func TestScalableInverseBloomFilterNeverFalsePositive(t *testing.T) {
+	s, err := NewScalableInverseBloomFilter(8, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Observe([]byte("never-seen")) {
+		t.Error("Observe on a never-seen key = true, want false")
+	}
+}
+
+func TestScalableInverseBloomFilterCompactDropsOldestLayer(t *testing.T) {
+	s, err := NewScalableInverseBloomFilter(8, 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLoadFactor(0.1)
+	for i := 0; i < 64; i++ {
+		s.Observe([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	}
+	before := s.Layers()
+	if before <= 1 {
+		t.Fatalf("Layers() = %d, want > 1 before Compact", before)
+	}
+
+	s.Compact()
+	if got := s.Layers(); got != before-1 {
+		t.Errorf("Layers() after Compact = %d, want %d", got, before-1)
+	}
+
+	// Compact on a single remaining layer is a no-op.
+	for s.Layers() > 1 {
+		s.Compact()
+	}
+	s.Compact()
+	if got := s.Layers(); got != 1 {
+		t.Errorf("Layers() after compacting to one layer = %d, want 1", got)
+	}
+}
@@ -0,0 +1,244 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// binaryVersion is the only WriteTo/ReadFrom wire format version currently
+// understood by this package.
+const binaryVersion = 1
+
+var (
+	// ErrVersionMismatch is returned by UnmarshalBinary/ReadFrom when the
+	// encoded data was written by an incompatible version of this package.
+	ErrVersionMismatch = errors.New("filter: unsupported binary version")
+
+	// ErrHasherMismatch is returned by UnmarshalBinary/ReadFrom/Merge when
+	// the encoded or supplied filter was built with a different Hasher than
+	// the receiver, since its slots cannot be interpreted correctly without
+	// knowing the hash function that produced them.
+	ErrHasherMismatch = errors.New("filter: hasher identifier mismatch")
+
+	// ErrSizeMismatch is returned by ReadFrom/Merge when the encoded or
+	// supplied filter does not have the same capacity as the receiver.
+	ErrSizeMismatch = errors.New("filter: size mismatch")
+)
+
+// MarshalBinary encodes the filter's contents, including its size and hasher
+// identifier, so it can be restored with UnmarshalBinary. It implements
+// encoding.BinaryMarshaler.
+func (i *InverseBloomFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := i.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores the filter's contents from data previously
+// produced by MarshalBinary. The receiver must already be constructed with
+// the same size and Hasher identifier as the filter that was marshaled;
+// UnmarshalBinary returns ErrHasherMismatch or ErrSizeMismatch otherwise. It
+// implements encoding.BinaryUnmarshaler.
+func (i *InverseBloomFilter) UnmarshalBinary(data []byte) error {
+	_, err := i.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// WriteTo writes a snapshot of the filter to w: a version byte, the hasher
+// identifier, the filter size, and for every slot a length prefix - zero for
+// an empty slot, otherwise the key's length plus one - followed by the slot's
+// key. It implements io.WriterTo.
+func (i *InverseBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+
+	if err := binary.Write(w, binary.LittleEndian, uint8(binaryVersion)); err != nil {
+		return n, err
+	}
+	n++
+
+	if err := binary.Write(w, binary.LittleEndian, uint8(len(i.hasherID))); err != nil {
+		return n, err
+	}
+	n++
+	written, err := io.WriteString(w, i.hasherID)
+	n += int64(written)
+	if err != nil {
+		return n, err
+	}
+
+	size := i.Size()
+	if err := binary.Write(w, binary.LittleEndian, uint64(size)); err != nil {
+		return n, err
+	}
+	n += 8
+
+	for idx := 0; idx < size; idx++ {
+		occupied, offset, length := unpackWord(atomic.LoadUint64(i.wordSlot(uint64(idx))))
+		var key []byte
+		var prefix uint32
+		if occupied {
+			key = i.arena.read(offset, length)
+			prefix = uint32(len(key) + 1)
+		}
+		if err := binary.Write(w, binary.LittleEndian, prefix); err != nil {
+			return n, err
+		}
+		n += 4
+		if !occupied {
+			continue
+		}
+		written, err := w.Write(key)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// ReadFrom restores the filter's contents from a snapshot previously written
+// by WriteTo. The receiver must already be constructed with the same size
+// and Hasher identifier as the filter that was written; ReadFrom returns
+// ErrHasherMismatch or ErrSizeMismatch otherwise. It implements
+// io.ReaderFrom.
+func (i *InverseBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+
+	var version uint8
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return n, err
+	}
+	n++
+	if version != binaryVersion {
+		return n, ErrVersionMismatch
+	}
+
+	var idLen uint8
+	if err := binary.Read(r, binary.LittleEndian, &idLen); err != nil {
+		return n, err
+	}
+	n++
+	idBytes := make([]byte, idLen)
+	read, err := io.ReadFull(r, idBytes)
+	n += int64(read)
+	if err != nil {
+		return n, err
+	}
+	if string(idBytes) != i.hasherID {
+		return n, ErrHasherMismatch
+	}
+
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return n, err
+	}
+	n += 8
+	if size != uint64(i.Size()) {
+		return n, ErrSizeMismatch
+	}
+
+	for idx := uint64(0); idx < size; idx++ {
+		var keyLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+			return n, err
+		}
+		n += 4
+		if keyLen == 0 {
+			atomic.StoreUint64(i.wordSlot(idx), 0)
+			atomic.StoreUint32(i.hashSlot(idx), 0)
+			continue
+		}
+		key := make([]byte, keyLen-1)
+		read, err := io.ReadFull(r, key)
+		n += int64(read)
+		if err != nil {
+			return n, err
+		}
+		offset, length := i.arena.append(key)
+		atomic.StoreUint64(i.wordSlot(idx), packWord(offset, length))
+		atomic.StoreUint32(i.hashSlot(idx), uint32(i.hash(key)))
+	}
+
+	return n, nil
+}
+
+// ConflictResolver picks which key to keep for a slot when two filters are
+// merged; it is called for every slot. ours is the receiver's current key
+// for the slot, theirs is the other filter's; either may be nil if that
+// filter has not observed anything at the slot. Returning nil leaves the
+// slot empty.
+type ConflictResolver func(ours, theirs []byte) []byte
+
+// keepTheirs is the default ConflictResolver used by Merge: it prefers the
+// other filter's key, treating it as the newer observation.
+func keepTheirs(ours, theirs []byte) []byte {
+	if theirs != nil {
+		return theirs
+	}
+	return ours
+}
+
+// Merge combines other into i, slot-by-slot, keeping other's key for any
+// slot where other has observed one. This is useful for combining the
+// results of sharding a deduplication workload across goroutines or
+// machines, each with their own same-sized filter, into one. Both filters
+// must have the same size and hasher identifier, or Merge returns
+// ErrSizeMismatch or ErrHasherMismatch.
+func (i *InverseBloomFilter) Merge(other *InverseBloomFilter) error {
+	return i.MergeWithResolver(other, keepTheirs)
+}
+
+// MergeWithResolver combines other into i like Merge, but calls resolve for
+// every slot to decide which key, if any, to keep, instead of always
+// preferring other's.
+func (i *InverseBloomFilter) MergeWithResolver(other *InverseBloomFilter, resolve ConflictResolver) error {
+	size := i.Size()
+	if size != other.Size() {
+		return ErrSizeMismatch
+	}
+	if i.hasherID != other.hasherID {
+		return ErrHasherMismatch
+	}
+
+	for idx := 0; idx < size; idx++ {
+		theirOccupied, theirOffset, theirLength := unpackWord(atomic.LoadUint64(other.wordSlot(uint64(idx))))
+		var theirs []byte
+		if theirOccupied {
+			theirs = other.arena.read(theirOffset, theirLength)
+		}
+
+		ourOccupied, ourOffset, ourLength := unpackWord(atomic.LoadUint64(i.wordSlot(uint64(idx))))
+		var ours []byte
+		if ourOccupied {
+			ours = i.arena.read(ourOffset, ourLength)
+		}
+
+		merged := resolve(ours, theirs)
+		switch {
+		case merged == nil:
+			atomic.StoreUint64(i.wordSlot(uint64(idx)), 0)
+			atomic.StoreUint32(i.hashSlot(uint64(idx)), 0)
+		case bytes.Equal(merged, ours):
+			// Keep the existing slot as-is, including its arena location,
+			// rather than re-appending an entry for an equal copy.
+		default:
+			offset, length := i.arena.append(merged)
+			atomic.StoreUint64(i.wordSlot(uint64(idx)), packWord(offset, length))
+			atomic.StoreUint32(i.hashSlot(uint64(idx)), uint32(i.hash(merged)))
+		}
+	}
+
+	return nil
+}
+
+// String returns a human-readable summary of the filter for debugging.
+func (i *InverseBloomFilter) String() string {
+	return fmt.Sprintf("InverseBloomFilter{size: %d, hasher: %s}", i.Size(), i.hasherID)
+}
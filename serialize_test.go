@@ -0,0 +1,123 @@
+package filter
+
+import "testing"
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	f, err := NewInverseBloomFilterWithSeed(64, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := [][]byte{[]byte("alpha"), []byte("beta"), []byte("gamma")}
+	for _, k := range keys {
+		f.Observe(k)
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	restored, err := NewInverseBloomFilterWithSeed(64, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	for _, k := range keys {
+		if !restored.Check(k) {
+			t.Errorf("Check(%q) = false after round trip, want true", k)
+		}
+	}
+}
+
+func TestUnmarshalBinaryRejectsHasherMismatch(t *testing.T) {
+	seeded, err := NewInverseBloomFilterWithSeed(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeded.Observe([]byte("key"))
+	data, err := seeded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	differentSeed, err := NewInverseBloomFilterWithSeed(64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := differentSeed.UnmarshalBinary(data); err != ErrHasherMismatch {
+		t.Fatalf("UnmarshalBinary with different seed = %v, want ErrHasherMismatch", err)
+	}
+}
+
+func TestUnmarshalBinaryRejectsSizeMismatch(t *testing.T) {
+	small, err := NewInverseBloomFilterWithSeed(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small.Observe([]byte("key"))
+	data, err := small.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large, err := NewInverseBloomFilterWithSeed(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := large.UnmarshalBinary(data); err != ErrSizeMismatch {
+		t.Fatalf("UnmarshalBinary with different size = %v, want ErrSizeMismatch", err)
+	}
+}
+
+func TestMergeCombinesKeys(t *testing.T) {
+	a, err := NewInverseBloomFilterWithSeed(64, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewInverseBloomFilterWithSeed(64, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Observe([]byte("from-a"))
+	b.Observe([]byte("from-b"))
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if !a.Check([]byte("from-a")) {
+		t.Error("Check(from-a) = false after merge, want true")
+	}
+	if !a.Check([]byte("from-b")) {
+		t.Error("Check(from-b) = false after merge, want true")
+	}
+}
+
+func TestMergeRejectsSizeMismatch(t *testing.T) {
+	a, err := NewInverseBloomFilterWithSeed(64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewInverseBloomFilterWithSeed(256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(b); err != ErrSizeMismatch {
+		t.Fatalf("Merge with different size = %v, want ErrSizeMismatch", err)
+	}
+}
+
+func TestMergeRejectsHasherMismatch(t *testing.T) {
+	a, err := NewInverseBloomFilterWithSeed(64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewInverseBloomFilterWithSeed(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(b); err != ErrHasherMismatch {
+		t.Fatalf("Merge with different seed = %v, want ErrHasherMismatch", err)
+	}
+}
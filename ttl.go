@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrGenerationsTooSmall is returned by NewInverseBloomFilterWithTTL when
+// generations is less than 1.
+var ErrGenerationsTooSmall = errors.New("Cannot have fewer than one generation")
+
+// TTLInverseBloomFilter is a concurrent, probabilistic data structure, like
+// InverseBloomFilter, that additionally forgets observations older than
+// roughly generations*rotate. It holds a ring of generations
+// InverseBloomFilter instances; Observe records into the newest and checks
+// all of them, and Rotate retires the oldest generation by wiping it and
+// recycling it as the new newest. This bounds memory to a sliding time
+// window, which a single fixed-size InverseBloomFilter cannot do: a key can
+// otherwise occupy a slot indefinitely, blocking that slot from ever
+// reflecting a newer, colliding key.
+type TTLInverseBloomFilter struct {
+	mu          sync.RWMutex
+	generations []*InverseBloomFilter
+
+	ticker    *time.Ticker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewInverseBloomFilterWithTTL creates a TTLInverseBloomFilter with the given
+// number of generations, each an InverseBloomFilter of the given size. If
+// rotate is greater than zero, a background goroutine calls Rotate every
+// rotate interval; otherwise, the caller is responsible for calling Rotate
+// to age out old observations. It returns an error if size is not a valid
+// InverseBloomFilter size or generations is less than 1.
+func NewInverseBloomFilterWithTTL(size int, generations int, rotate time.Duration) (*TTLInverseBloomFilter, error) {
+	if generations < 1 {
+		return nil, ErrGenerationsTooSmall
+	}
+
+	gens := make([]*InverseBloomFilter, generations)
+	for idx := range gens {
+		f, err := NewInverseBloomFilter(size)
+		if err != nil {
+			return nil, err
+		}
+		gens[idx] = f
+	}
+
+	t := &TTLInverseBloomFilter{generations: gens}
+	if rotate > 0 {
+		t.ticker = time.NewTicker(rotate)
+		t.done = make(chan struct{})
+		go t.rotateLoop()
+	}
+	return t, nil
+}
+
+func (t *TTLInverseBloomFilter) rotateLoop() {
+	for {
+		select {
+		case <-t.ticker.C:
+			t.Rotate()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Observe marks a key as observed in the newest generation. It returns true
+// if the key has been previously observed by any live generation and false
+// if it has possibly not been observed yet. Like InverseBloomFilter.Observe,
+// it may report a false negative but will never report a false positive. An
+// observation older than roughly generations*rotate is forgotten, so a repeat
+// of that key is then reported as not observed.
+func (t *TTLInverseBloomFilter) Observe(key []byte) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	hit := t.generations[0].Observe(key)
+	if hit {
+		return true
+	}
+	for _, gen := range t.generations[1:] {
+		if gen.Check(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rotate retires the oldest generation: it is wiped and becomes the new
+// newest generation, so the next Observe starts recording into an empty
+// filter while the previously-newest generations age one step closer to
+// eviction. Rotate is safe to call concurrently with Observe and with itself.
+func (t *TTLInverseBloomFilter) Rotate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.generations)
+	oldest := t.generations[n-1]
+	oldest.Reset()
+	copy(t.generations[1:], t.generations[:n-1])
+	t.generations[0] = oldest
+}
+
+// Close stops the background rotation goroutine started by
+// NewInverseBloomFilterWithTTL, if rotate was greater than zero. It is a
+// no-op otherwise. Close does not affect callers using explicit Rotate. It
+// is safe to call more than once; only the first call has any effect.
+func (t *TTLInverseBloomFilter) Close() {
+	if t.ticker == nil {
+		return
+	}
+	t.closeOnce.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+	})
+}
+
+// Generations returns the number of generations this filter maintains.
+func (t *TTLInverseBloomFilter) Generations() int {
+	return len(t.generations)
+}
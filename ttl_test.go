@@ -0,0 +1,69 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLInverseBloomFilterObservesWithinWindow(t *testing.T) {
+	f, err := NewInverseBloomFilterWithTTL(64, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Observe([]byte("key")) {
+		t.Error("first Observe = true, want false")
+	}
+	if !f.Observe([]byte("key")) {
+		t.Error("second Observe = false, want true")
+	}
+}
+
+func TestTTLInverseBloomFilterAgesOutAfterEnoughRotations(t *testing.T) {
+	f, err := NewInverseBloomFilterWithTTL(64, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("key")
+	f.Observe(key)
+
+	// generations-1 rotations still leave the observation reachable in an
+	// older generation.
+	f.Rotate()
+	f.Rotate()
+	if !f.Observe(key) {
+		t.Error("Observe within the generation window = false, want true")
+	}
+
+	// generations more rotations evict the observation above, which
+	// re-recorded the key into generation 0, and the original observation
+	// along with it.
+	for i := 0; i < f.Generations(); i++ {
+		f.Rotate()
+	}
+	if f.Observe(key) {
+		t.Error("Observe after the generation window elapsed = true, want false")
+	}
+}
+
+func TestNewInverseBloomFilterWithTTLRejectsTooFewGenerations(t *testing.T) {
+	if _, err := NewInverseBloomFilterWithTTL(64, 0, 0); err != ErrGenerationsTooSmall {
+		t.Fatalf("NewInverseBloomFilterWithTTL with 0 generations = %v, want ErrGenerationsTooSmall", err)
+	}
+}
+
+func TestTTLInverseBloomFilterCloseWithoutRotateIsNoOp(t *testing.T) {
+	f, err := NewInverseBloomFilterWithTTL(64, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestTTLInverseBloomFilterCloseTwiceWithRotateDoesNotPanic(t *testing.T) {
+	f, err := NewInverseBloomFilterWithTTL(64, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f.Close()
+}